@@ -0,0 +1,335 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+
+	"sigs.k8s.io/yaml"
+
+	"istio.io/istio/pkg/rpccontroller/registry/coredns"
+
+	"istio.io/istio/pkg/log"
+)
+
+// FileConfig is the shape of the file passed via --config. It mirrors the
+// CLI flags so a deployment can keep most of its settings out of the pod
+// spec; at startup, any flag explicitly set on the command line still takes
+// precedence over the matching file value, except the log level, which the
+// file always sets. A hot reload applies the file's CoreDNS and LogLevel
+// values whether or not the matching flag was set on the command line.
+// Fields are grouped by whether a running
+// process can pick up a change to them: CoreDNS and LogLevel are re-read on
+// every file change, everything else only takes effect on restart.
+type FileConfig struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Master     string `json:"master,omitempty"`
+
+	HealthPort      int   `json:"healthPort,omitempty"`
+	MonitoringPort  int   `json:"monitoringPort,omitempty"`
+	EnableProfiling *bool `json:"enableProfiling,omitempty"`
+
+	// LogLevel is safe to reload: it is re-applied on every config file
+	// change via log.Configure.
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// CoreDNS is safe to reload: its fields are re-applied to the running
+	// coredns.Registry (if any) via Registry.Reload on every config file
+	// change.
+	CoreDNS struct {
+		Address        string   `json:"address,omitempty"`
+		EtcdKeyFile    string   `json:"etcdKeyFile,omitempty"`
+		EtcdCertFile   string   `json:"etcdCertFile,omitempty"`
+		EtcdCaCertFile string   `json:"etcdCaCertFile,omitempty"`
+		EtcdEndpoints  []string `json:"etcdEndpoints,omitempty"`
+	} `json:"coredns,omitempty"`
+
+	// LeaderElection, Registries, Nacos, Zookeeper, and Consul only take
+	// effect on process start; changing them in the file requires a
+	// restart to pick up.
+	LeaderElection struct {
+		Enabled           *bool  `json:"enabled,omitempty"`
+		LeaseDuration     string `json:"leaseDuration,omitempty"`
+		RenewDeadline     string `json:"renewDeadline,omitempty"`
+		RetryPeriod       string `json:"retryPeriod,omitempty"`
+		ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	} `json:"leaderElection,omitempty"`
+
+	Registries []string `json:"registries,omitempty"`
+
+	Nacos struct {
+		Endpoints []string `json:"endpoints,omitempty"`
+		Namespace string   `json:"namespace,omitempty"`
+		Group     string   `json:"group,omitempty"`
+	} `json:"nacos,omitempty"`
+
+	Zookeeper struct {
+		Hosts    []string `json:"hosts,omitempty"`
+		BasePath string   `json:"basePath,omitempty"`
+	} `json:"zookeeper,omitempty"`
+
+	Consul struct {
+		Addr  string `json:"addr,omitempty"`
+		Token string `json:"token,omitempty"`
+	} `json:"consul,omitempty"`
+}
+
+// loadConfigFile reads and parses path as YAML or JSON (sigs.k8s.io/yaml
+// accepts both).
+func loadConfigFile(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	fc := &FileConfig{}
+	if err := yaml.Unmarshal(data, fc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return fc, nil
+}
+
+// applyFileConfig copies fc's values into the package-level flag variables,
+// skipping any flag the user set explicitly on the command line so that CLI
+// flags win over the config file. The file's LogLevel is the exception: it is
+// applied even when a log level was set on the command line.
+func applyFileConfig(flags *pflag.FlagSet, fc *FileConfig) {
+	set := func(name string, apply func()) {
+		if !flags.Changed(name) {
+			apply()
+		}
+	}
+
+	if fc.Kubeconfig != "" {
+		set("kubeconfig", func() { kubeconfig = fc.Kubeconfig })
+	}
+	if fc.Master != "" {
+		set("master", func() { masterURL = fc.Master })
+	}
+	if fc.HealthPort != 0 {
+		set("healthport", func() { healthPort = fc.HealthPort })
+	}
+	if fc.MonitoringPort != 0 {
+		set("monitoring-port", func() { monitoringPort = fc.MonitoringPort })
+	}
+	if fc.EnableProfiling != nil {
+		set("enable-profiling", func() { enableProfiling = *fc.EnableProfiling })
+	}
+	if fc.LogLevel != "" {
+		loggingOptions.OutputLevels = fmt.Sprintf("default:%s", fc.LogLevel)
+	}
+
+	if fc.CoreDNS.Address != "" {
+		set("coredns", func() { corednsAddress = fc.CoreDNS.Address })
+	}
+	if fc.CoreDNS.EtcdKeyFile != "" {
+		set("etcdkeyfile", func() { etcdKeyFile = fc.CoreDNS.EtcdKeyFile })
+	}
+	if fc.CoreDNS.EtcdCertFile != "" {
+		set("etcdcertfile", func() { etcdCertFile = fc.CoreDNS.EtcdCertFile })
+	}
+	if fc.CoreDNS.EtcdCaCertFile != "" {
+		set("etcdcacertfile", func() { etcdCaCertile = fc.CoreDNS.EtcdCaCertFile })
+	}
+	if len(fc.CoreDNS.EtcdEndpoints) > 0 {
+		set("etcdendpoints", func() { etcdEndpoints = strings.Join(fc.CoreDNS.EtcdEndpoints, ",") })
+	}
+
+	if fc.LeaderElection.Enabled != nil {
+		set("leader-elect", func() { leaderElect = *fc.LeaderElection.Enabled })
+	}
+	if d, err := time.ParseDuration(fc.LeaderElection.LeaseDuration); err == nil {
+		set("leader-elect-lease-duration", func() { leaderElectLeaseDuration = d })
+	}
+	if d, err := time.ParseDuration(fc.LeaderElection.RenewDeadline); err == nil {
+		set("leader-elect-renew-deadline", func() { leaderElectRenewDeadline = d })
+	}
+	if d, err := time.ParseDuration(fc.LeaderElection.RetryPeriod); err == nil {
+		set("leader-elect-retry-period", func() { leaderElectRetryPeriod = d })
+	}
+	if fc.LeaderElection.ResourceNamespace != "" {
+		set("leader-elect-resource-namespace", func() { leaderElectResourceNamespace = fc.LeaderElection.ResourceNamespace })
+	}
+
+	if len(fc.Registries) > 0 {
+		set("registry", func() { registryBackends = fc.Registries })
+	}
+	if len(fc.Nacos.Endpoints) > 0 {
+		set("nacos-endpoints", func() { nacosEndpoints = fc.Nacos.Endpoints })
+	}
+	if fc.Nacos.Namespace != "" {
+		set("nacos-namespace", func() { nacosNamespace = fc.Nacos.Namespace })
+	}
+	if fc.Nacos.Group != "" {
+		set("nacos-group", func() { nacosGroup = fc.Nacos.Group })
+	}
+	if len(fc.Zookeeper.Hosts) > 0 {
+		set("zk-hosts", func() { zkHosts = fc.Zookeeper.Hosts })
+	}
+	if fc.Zookeeper.BasePath != "" {
+		set("zk-base-path", func() { zkBasePath = fc.Zookeeper.BasePath })
+	}
+	if fc.Consul.Addr != "" {
+		set("consul-addr", func() { consulAddr = fc.Consul.Addr })
+	}
+	if fc.Consul.Token != "" {
+		set("consul-token", func() { consulToken = fc.Consul.Token })
+	}
+}
+
+// coreDNSConfigMu guards currentCoreDNSConfig and activeCoreDNSRegistry, the
+// two pieces of state a config file hot-reload needs to update: the config
+// /readyz probes against, and the registry whose etcd/CoreDNS connections
+// get swapped.
+var (
+	coreDNSConfigMu       sync.RWMutex
+	currentCoreDNSConfig  coredns.Config
+	activeCoreDNSRegistry *coredns.Registry
+)
+
+func setCoreDNSConfig(c coredns.Config) {
+	coreDNSConfigMu.Lock()
+	defer coreDNSConfigMu.Unlock()
+	currentCoreDNSConfig = c
+}
+
+func getCoreDNSConfig() coredns.Config {
+	coreDNSConfigMu.RLock()
+	defer coreDNSConfigMu.RUnlock()
+	return currentCoreDNSConfig
+}
+
+func setActiveCoreDNSRegistry(r *coredns.Registry) {
+	coreDNSConfigMu.Lock()
+	defer coreDNSConfigMu.Unlock()
+	activeCoreDNSRegistry = r
+}
+
+func getActiveCoreDNSRegistry() *coredns.Registry {
+	coreDNSConfigMu.RLock()
+	defer coreDNSConfigMu.RUnlock()
+	return activeCoreDNSRegistry
+}
+
+// watchConfigFile watches path's parent directory for changes (rather than
+// the file itself, since Kubernetes ConfigMap volumes update by atomically
+// swapping a "..data" symlink to a new timestamped directory instead of
+// writing or recreating path itself) and calls reloadCoreDNSConfig whenever
+// path changes. It returns once stopCh is closed.
+func watchConfigFile(path string, stopCh <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorf("failed to start config file watcher: %s", err.Error())
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		log.Errorf("failed to watch %s: %s", filepath.Dir(path), err.Error())
+		return
+	}
+
+	// realPath is what path currently resolves to, following the "..data"
+	// symlink ConfigMap volumes use. A ConfigMap update swaps that symlink
+	// to a new directory without ever writing or recreating path itself, so
+	// the fsnotify event for it names "..data", not path; comparing realPath
+	// across events (the standard viper/fsnotify workaround) catches that
+	// swap in addition to a direct write or recreate of path.
+	realPath, _ := filepath.EvalSymlinks(path)
+
+	log.Infof("Watching %s for config changes", path)
+	const writeOrCreateMask = fsnotify.Write | fsnotify.Create
+	for {
+		select {
+		case <-stopCh:
+			return
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("config file watcher error: %s", err.Error())
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			currentRealPath, _ := filepath.EvalSymlinks(path)
+			wroteOrRecreatedPath := filepath.Clean(event.Name) == filepath.Clean(path) && event.Op&writeOrCreateMask != 0
+			symlinkSwapped := currentRealPath != "" && currentRealPath != realPath
+			if !wroteOrRecreatedPath && !symlinkSwapped {
+				continue
+			}
+
+			realPath = currentRealPath
+			reloadCoreDNSConfig(path)
+		}
+	}
+}
+
+// reloadCoreDNSConfig re-reads path and applies its safe-to-reload fields
+// (CoreDNS connection settings, log level) to the running process. All
+// other fields require a restart and are ignored here.
+func reloadCoreDNSConfig(path string) {
+	fc, err := loadConfigFile(path)
+	if err != nil {
+		log.Errorf("failed to reload config %s: %s", path, err.Error())
+		return
+	}
+
+	updated := getCoreDNSConfig()
+	if fc.CoreDNS.Address != "" {
+		updated.Address = fc.CoreDNS.Address
+	}
+	if fc.CoreDNS.EtcdKeyFile != "" {
+		updated.EtcdKeyFile = fc.CoreDNS.EtcdKeyFile
+	}
+	if fc.CoreDNS.EtcdCertFile != "" {
+		updated.EtcdCertFile = fc.CoreDNS.EtcdCertFile
+	}
+	if fc.CoreDNS.EtcdCaCertFile != "" {
+		updated.EtcdCaCertFile = fc.CoreDNS.EtcdCaCertFile
+	}
+	if len(fc.CoreDNS.EtcdEndpoints) > 0 {
+		updated.EtcdEndpoints = fc.CoreDNS.EtcdEndpoints
+	}
+
+	if reg := getActiveCoreDNSRegistry(); reg == nil {
+		setCoreDNSConfig(updated)
+	} else if err := reg.Reload(updated); err != nil {
+		log.Errorf("failed to reload coredns registry: %s", err.Error())
+	} else {
+		setCoreDNSConfig(updated)
+	}
+
+	if fc.LogLevel != "" {
+		loggingOptions.OutputLevels = fmt.Sprintf("default:%s", fc.LogLevel)
+		if err := log.Configure(loggingOptions); err != nil {
+			log.Errorf("failed to apply reloaded log level %q: %s", fc.LogLevel, err.Error())
+		} else {
+			log.Infof("reloaded log level to %s", fc.LogLevel)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// TestApplyFileConfigPrecedence checks that a flag explicitly set on the CLI
+// keeps its CLI value even when the config file sets the same field, while a
+// flag left untouched on the CLI picks up the file's value.
+func TestApplyFileConfigPrecedence(t *testing.T) {
+	origMaster, origHealthPort, origRegistries := masterURL, healthPort, registryBackends
+	t.Cleanup(func() {
+		masterURL, healthPort, registryBackends = origMaster, origHealthPort, origRegistries
+	})
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&masterURL, "master", "", "")
+	flags.IntVar(&healthPort, "healthport", 12345, "")
+	flags.StringArrayVar(&registryBackends, "registry", []string{"coredns"}, "")
+
+	if err := flags.Set("master", "https://cli-master:6443"); err != nil {
+		t.Fatalf("failed to set master flag: %v", err)
+	}
+	// healthport and registry are left at their defaults, i.e. never
+	// Changed on the CLI, so the file config should be free to override them.
+
+	fc := &FileConfig{
+		Master:     "https://file-master:6443",
+		HealthPort: 9999,
+		Registries: []string{"consul"},
+	}
+
+	applyFileConfig(flags, fc)
+
+	if masterURL != "https://cli-master:6443" {
+		t.Errorf("masterURL = %q, want the CLI value to win over the file value", masterURL)
+	}
+	if healthPort != 9999 {
+		t.Errorf("healthPort = %d, want the file value 9999 since the flag wasn't set on the CLI", healthPort)
+	}
+	if len(registryBackends) != 1 || registryBackends[0] != "consul" {
+		t.Errorf("registryBackends = %v, want [consul] from the file config", registryBackends)
+	}
+}
+
+// TestApplyFileConfigIgnoresZeroValues checks that empty/zero fields in the
+// file config are left alone rather than clobbering an existing value.
+func TestApplyFileConfigIgnoresZeroValues(t *testing.T) {
+	origHealthPort := healthPort
+	t.Cleanup(func() { healthPort = origHealthPort })
+
+	healthPort = 12345
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.IntVar(&healthPort, "healthport", 12345, "")
+
+	applyFileConfig(flags, &FileConfig{})
+
+	if healthPort != 12345 {
+		t.Errorf("healthPort = %d, want it unchanged by a zero-value file config", healthPort)
+	}
+}
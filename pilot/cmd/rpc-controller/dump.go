@@ -0,0 +1,259 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/yaml"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+	clientset "istio.io/istio/pkg/rpccontroller/clientset/versioned"
+	"istio.io/istio/pkg/rpccontroller/registry/coredns"
+
+	"istio.io/istio/pkg/log"
+)
+
+var (
+	dumpOutput    string
+	dumpNamespace string
+	dumpName      string
+	dumpLogFile   string
+	dumpLogLines  int
+)
+
+// dumpCmd collects everything an operator would otherwise have to gather by
+// hand with kubectl, etcdctl, and dig, and writes it into a single artifact
+// tree that can be attached to a bug report.
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "snapshot RpcService diagnostics into an artifact directory",
+	Long: "dump collects, for each matching RpcService, its CR and status, " +
+		"the corresponding Endpoints and Pods, the raw and decoded CoreDNS " +
+		"etcd keys, a CoreDNS health probe, and recent controller logs, and " +
+		"writes them under <output>/<namespace>/<name>/.",
+	RunE: runDump,
+}
+
+func runDump(c *cobra.Command, args []string) error {
+	if err := log.Configure(loggingOptions); err != nil {
+		return err
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
+	if err != nil {
+		return fmt.Errorf("error building kubeconfig: %v", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building kubernetes clientset: %v", err)
+	}
+
+	watcherClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("error building watcher clientset: %v", err)
+	}
+
+	if dumpName != "" && dumpNamespace == "" {
+		return fmt.Errorf("--name requires --namespace")
+	}
+
+	namespace := dumpNamespace
+	if namespace == "" {
+		namespace = metav1.NamespaceAll
+	}
+
+	var services []rpcsvcv1.RpcService
+	if dumpName != "" {
+		svc, err := watcherClient.Rpccontroller().V1().RpcServices(dumpNamespace).Get(dumpName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting RpcService %s/%s: %v", dumpNamespace, dumpName, err)
+		}
+		services = append(services, *svc)
+	} else {
+		list, err := watcherClient.Rpccontroller().V1().RpcServices(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing RpcServices: %v", err)
+		}
+		services = list.Items
+	}
+
+	coreDNSConfig := coredns.Config{
+		Address:        corednsAddress,
+		EtcdKeyFile:    etcdKeyFile,
+		EtcdCertFile:   etcdCertFile,
+		EtcdCaCertFile: etcdCaCertile,
+		EtcdEndpoints:  strings.Split(etcdEndpoints, ","),
+	}
+
+	for i := range services {
+		if err := dumpOne(kubeClient, coreDNSConfig, &services[i]); err != nil {
+			log.Errorf("error dumping RpcService %s/%s: %s", services[i].Namespace, services[i].Name, err.Error())
+		}
+	}
+
+	log.Infof("Wrote diagnostics for %d RpcService(s) to %s", len(services), dumpOutput)
+	return nil
+}
+
+func dumpOne(kubeClient kubernetes.Interface, coreDNSConfig coredns.Config, svc *rpcsvcv1.RpcService) error {
+	dir := filepath.Join(dumpOutput, svc.Namespace, svc.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	if err := writeYAML(filepath.Join(dir, "rpcservice.yaml"), svc); err != nil {
+		return err
+	}
+
+	endpoints, err := kubeClient.CoreV1().Endpoints(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("no Endpoints for %s/%s: %s", svc.Namespace, svc.Name, err.Error())
+	} else {
+		if err := writeYAML(filepath.Join(dir, "endpoints.yaml"), endpoints); err != nil {
+			return err
+		}
+		if err := dumpPods(kubeClient, dir, endpoints); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys, err := coredns.DumpKeys(ctx, coreDNSConfig, svc.Namespace, svc.Name)
+	if err != nil {
+		log.Warnf("failed to dump etcd keys for %s/%s: %s", svc.Namespace, svc.Name, err.Error())
+	} else if err := writeJSON(filepath.Join(dir, "etcd-keys.json"), keys); err != nil {
+		return err
+	}
+
+	health := map[string]string{"status": "ok"}
+	if err := coredns.CheckCoreDNS(ctx, coreDNSConfig.Address); err != nil {
+		health["status"] = "error"
+		health["error"] = err.Error()
+	}
+	if err := writeJSON(filepath.Join(dir, "coredns-health.json"), health); err != nil {
+		return err
+	}
+
+	if dumpLogFile != "" {
+		if err := dumpLogs(filepath.Join(dir, "controller.log")); err != nil {
+			log.Warnf("failed to dump controller logs: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func dumpPods(kubeClient kubernetes.Interface, dir string, endpoints *corev1.Endpoints) error {
+	var pods []corev1.Pod
+	seen := map[string]bool{}
+
+	for _, subset := range endpoints.Subsets {
+		addrs := append(append([]corev1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...)
+		for _, addr := range addrs {
+			if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" || seen[addr.TargetRef.Name] {
+				continue
+			}
+			seen[addr.TargetRef.Name] = true
+
+			pod, err := kubeClient.CoreV1().Pods(addr.TargetRef.Namespace).Get(addr.TargetRef.Name, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("failed to get pod %s/%s: %s", addr.TargetRef.Namespace, addr.TargetRef.Name, err.Error())
+				continue
+			}
+			pods = append(pods, *pod)
+		}
+	}
+
+	if len(pods) == 0 {
+		return nil
+	}
+	return writeYAML(filepath.Join(dir, "pods.yaml"), pods)
+}
+
+// dumpLogs writes the last dumpLogLines lines of dumpLogFile to dest.
+func dumpLogs(dest string) error {
+	content, err := ioutil.ReadFile(dumpLogFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", dumpLogFile, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) > dumpLogLines {
+		lines = lines[len(lines)-dumpLogLines:]
+	}
+
+	return ioutil.WriteFile(dest, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func writeYAML(path string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", path, err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func init() {
+	dumpCmd.Flags().StringVar(&dumpOutput, "output", "./artifacts", "Directory diagnostics are written under.")
+	dumpCmd.Flags().StringVar(&dumpNamespace, "namespace", "", "Only dump RpcServices in this namespace. Defaults to all namespaces.")
+	dumpCmd.Flags().StringVar(&dumpName, "name", "", "Only dump the RpcService with this name. Requires --namespace.")
+	dumpCmd.Flags().StringVar(&dumpLogFile, "log-file", "", "Path to the controller's log file. If set, the last --log-lines lines are included in the bundle.")
+	dumpCmd.Flags().IntVar(&dumpLogLines, "log-lines", 200, "Number of trailing controller log lines to include when --log-file is set.")
+
+	dumpCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	dumpCmd.PersistentFlags().StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	dumpCmd.PersistentFlags().StringVar(&corednsAddress, "coredns", "", "The address of coredns.")
+	dumpCmd.PersistentFlags().StringVar(&etcdKeyFile, "etcdkeyfile", "", "Path to etcdkeyfile.")
+	dumpCmd.PersistentFlags().StringVar(&etcdCertFile, "etcdcertfile", "", "Path to etcdcertfile.")
+	dumpCmd.PersistentFlags().StringVar(&etcdCaCertile, "etcdcacertfile", "", "Path to etcdcacertfile.")
+	dumpCmd.PersistentFlags().StringVar(&etcdEndpoints, "etcdendpoints", "", "Comma-separated list of etcd endpoints.")
+
+	rootCmd.AddCommand(dumpCmd)
+}
@@ -18,23 +18,37 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
-	kubeinformers "k8s.io/client-go/informers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 
-	"os"
-	"strings"
-
 	clientset "istio.io/istio/pkg/rpccontroller/clientset/versioned"
 	"istio.io/istio/pkg/rpccontroller/controller"
 	informers "istio.io/istio/pkg/rpccontroller/informers/externalversions"
+	"istio.io/istio/pkg/rpccontroller/registry"
+	"istio.io/istio/pkg/rpccontroller/registry/consul"
+	"istio.io/istio/pkg/rpccontroller/registry/coredns"
+	"istio.io/istio/pkg/rpccontroller/registry/nacos"
+	"istio.io/istio/pkg/rpccontroller/registry/zookeeper"
 	"istio.io/istio/pkg/signals"
 
 	"istio.io/istio/pkg/cmd"
@@ -43,6 +57,12 @@ import (
 	"istio.io/istio/pkg/version"
 )
 
+const (
+	// leaderElectionResourceName is the name of the Lease object the
+	// rpc-controller replicas coordinate on.
+	leaderElectionResourceName = "rpc-controller"
+)
+
 var (
 	masterURL  string
 	kubeconfig string
@@ -50,6 +70,10 @@ var (
 	// for health check
 	healthPort int
 
+	// monitoringPort serves /metrics and, if enabled, /debug/pprof/*.
+	monitoringPort  int
+	enableProfiling bool
+
 	// core dns address
 	corednsAddress string
 
@@ -57,8 +81,59 @@ var (
 	etcdCertFile  string
 	etcdCaCertile string
 	etcdEndpoints string
+
+	// configFile, if set, is a YAML or JSON file unmarshalled into a
+	// FileConfig whose values seed any flag not explicitly passed on the
+	// command line. It is also watched for changes so its safe-to-reload
+	// fields can be picked up without a restart; see applyFileConfig and
+	// reloadCoreDNSConfig.
+	configFile string
+
+	// leader election
+	leaderElect                  bool
+	leaderElectLeaseDuration     time.Duration
+	leaderElectRenewDeadline     time.Duration
+	leaderElectRetryPeriod       time.Duration
+	leaderElectResourceNamespace string
+
+	// registries selects which ServiceRegistry backends RpcServices are
+	// published into. May be repeated to fan out to several at once.
+	registryBackends []string
+
+	nacosEndpoints []string
+	nacosNamespace string
+	nacosGroup     string
+	zkHosts        []string
+	zkBasePath     string
+	consulAddr     string
+	consulToken    string
 )
 
+// currentLeader holds the identity of the elected leader, as last observed
+// by this process. It is surfaced on /livez so operators can tell which
+// replica is active without digging through the Lease object.
+var currentLeader = "unknown"
+
+// activeController, guarded by activeControllerMu, is the Controller running
+// on this replica, if any. A follower that hasn't been elected leader has no
+// active controller, so /readyz reports not-ready until it is.
+var (
+	activeControllerMu sync.RWMutex
+	activeController   *controller.Controller
+)
+
+func setActiveController(c *controller.Controller) {
+	activeControllerMu.Lock()
+	defer activeControllerMu.Unlock()
+	activeController = c
+}
+
+func getActiveController() *controller.Controller {
+	activeControllerMu.RLock()
+	defer activeControllerMu.RUnlock()
+	return activeController
+}
+
 var (
 	loggingOptions = log.DefaultOptions()
 
@@ -78,64 +153,346 @@ var (
 			}
 			log.Infof("Version %s", version.Info.String())
 
-			// start http health check server
-			go startHealthCheckHTTPServer(healthPort)
-
 			stopCh := signals.SetupSignalHandler()
 
+			if configFile != "" {
+				fc, err := loadConfigFile(configFile)
+				if err != nil {
+					return err
+				}
+				applyFileConfig(c.Flags(), fc)
+			}
+
+			// --registry may be repeated; collapse duplicates (e.g. a file
+			// config and a CLI flag both naming "coredns") so registries,
+			// metrics and /readyz checks aren't built or registered twice
+			// for the same backend.
+			registryBackends = dedupeStrings(registryBackends)
+
 			if err := log.Configure(loggingOptions); err != nil {
 				return err
 			}
 
+			coreDNSConfig := coredns.Config{
+				Address:        corednsAddress,
+				EtcdKeyFile:    etcdKeyFile,
+				EtcdCertFile:   etcdCertFile,
+				EtcdCaCertFile: etcdCaCertile,
+				EtcdEndpoints:  strings.Split(etcdEndpoints, ","),
+			}
+			setCoreDNSConfig(coreDNSConfig)
+
+			// start http health check server
+			go startHealthCheckHTTPServer(healthPort, stopCh)
+
+			if configFile != "" {
+				go watchConfigFile(configFile, stopCh)
+			}
+
+			controller.RegisterMetrics(prometheus.DefaultRegisterer)
+			registerBackendMetrics(prometheus.DefaultRegisterer)
+			go startMonitoringHTTPServer(monitoringPort, enableProfiling)
+
+			registries, err := buildRegistries(coreDNSConfig)
+			if err != nil {
+				log.Errorf("Error building service registries: %s", err.Error())
+				return err
+			}
+
 			cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 			if err != nil {
 				log.Errorf("Error building kubeconfig: %s", err.Error())
 				return err
 			}
 
-			config := &controller.Config{}
-			config.CoreDnsAddress = corednsAddress
-			config.EtcdKeyFile = etcdKeyFile
-			config.EtcdCertFile = etcdCertFile
-			config.EtcdCaCertFile = etcdCaCertile
-			config.EtcdEndpoints = strings.Split(etcdEndpoints, ",")
-
 			kubeClient, err := kubernetes.NewForConfig(cfg)
 			if err != nil {
 				log.Errorf("Error building kubernetes clientset: %s", err.Error())
 				return err
 			}
 
-			kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-
 			watcherClient, err := clientset.NewForConfig(cfg)
 			if err != nil {
 				log.Errorf("Error building watcher clientset: %s", err.Error())
 				return err
 			}
 
-			watcherInformerFactory := informers.NewSharedInformerFactory(watcherClient, time.Second*30)
+			run := func(leaderCtx context.Context) {
+				// runStopCh closes on whichever comes first: the process-wide
+				// stopCh (SIGTERM) or leaderCtx being cancelled (lease lost),
+				// so a replica that loses leadership actually stops
+				// reconciling instead of racing the new leader.
+				runStopCh := mergeStopChannels(stopCh, leaderCtx)
+
+				// No informer is requested for any core Kubernetes resource
+				// today, so there is no kubeClient-backed informer factory
+				// to start here; kubeClient itself is still passed to
+				// NewController and used directly for the leader election
+				// lock.
+				watcherInformerFactory := informers.NewSharedInformerFactory(watcherClient, time.Second*30)
 
-			controller := controller.NewController(kubeClient, watcherClient,
-				watcherInformerFactory.Rpccontroller().V1().RpcServices(), config, stopCh)
+				rpcController := controller.NewController(kubeClient, watcherClient,
+					watcherInformerFactory.Rpccontroller().V1().RpcServices(), registries, runStopCh)
 
-			go kubeInformerFactory.Start(stopCh)
-			go watcherInformerFactory.Start(stopCh)
+				go watcherInformerFactory.Start(runStopCh)
 
-			if err = controller.Run(2); err != nil {
-				log.Errorf("Error running controller: %s", err.Error())
+				setActiveController(rpcController)
+				defer setActiveController(nil)
+
+				if err := rpcController.Run(2); err != nil {
+					log.Errorf("Error running controller: %s", err.Error())
+				}
+			}
+
+			if !leaderElect {
+				run(context.Background())
+				return nil
+			}
+
+			id, err := os.Hostname()
+			if err != nil {
 				return err
 			}
+			id = id + "_" + string(uuid.NewUUID())
+
+			lock, err := resourcelock.New(
+				resourcelock.LeasesResourceLock,
+				leaderElectResourceNamespace,
+				leaderElectionResourceName,
+				kubeClient.CoreV1(),
+				kubeClient.CoordinationV1(),
+				resourcelock.ResourceLockConfig{
+					Identity:      id,
+					EventRecorder: loggingEventRecorder{},
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("error creating leader election lock: %v", err)
+			}
+
+			// electionCtx is cancelled when stopCh closes so that SIGTERM
+			// coordinates with leader election: RunOrDie's renew loop
+			// stops, OnStartedLeading's derived context is cancelled, and
+			// run above exits instead of the process hanging until a
+			// second forced signal.
+			electionCtx, cancelElection := context.WithCancel(context.Background())
+			defer cancelElection()
+			go func() {
+				<-stopCh
+				cancelElection()
+			}()
+
+			leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+				Lock:          lock,
+				LeaseDuration: leaderElectLeaseDuration,
+				RenewDeadline: leaderElectRenewDeadline,
+				RetryPeriod:   leaderElectRetryPeriod,
+				Callbacks: leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(ctx context.Context) {
+						log.Infof("%s: became leader, starting controller", id)
+						currentLeader = id
+						run(ctx)
+					},
+					OnStoppedLeading: func() {
+						log.Infof("%s: lost leadership, shutting down", id)
+					},
+					OnNewLeader: func(identity string) {
+						currentLeader = identity
+						if identity != id {
+							log.Infof("leader is now %s", identity)
+						}
+					},
+				},
+			})
 
 			return nil
 		},
 	}
 )
 
-func startHealthCheckHTTPServer(port int) {
+// buildRegistries constructs one registry.ServiceRegistry per backend named
+// in --registry, in the order given.
+func buildRegistries(coreDNSConfig coredns.Config) ([]registry.ServiceRegistry, error) {
+	registries := make([]registry.ServiceRegistry, 0, len(registryBackends))
+
+	for _, backend := range registryBackends {
+		switch backend {
+		case "coredns":
+			reg, err := coredns.New(coreDNSConfig)
+			if err != nil {
+				return nil, fmt.Errorf("coredns: %v", err)
+			}
+			setActiveCoreDNSRegistry(reg)
+			registries = append(registries, reg)
+		case "nacos":
+			reg, err := nacos.New(nacos.Config{Endpoints: nacosEndpoints, NamespaceID: nacosNamespace, Group: nacosGroup})
+			if err != nil {
+				return nil, fmt.Errorf("nacos: %v", err)
+			}
+			registries = append(registries, reg)
+		case "zookeeper":
+			reg, err := zookeeper.New(zookeeper.Config{Hosts: zkHosts, BasePath: zkBasePath})
+			if err != nil {
+				return nil, fmt.Errorf("zookeeper: %v", err)
+			}
+			registries = append(registries, reg)
+		case "consul":
+			reg, err := consul.New(consul.Config{Addr: consulAddr, Token: consulToken})
+			if err != nil {
+				return nil, fmt.Errorf("consul: %v", err)
+			}
+			registries = append(registries, reg)
+		default:
+			return nil, fmt.Errorf("unknown --registry backend %q", backend)
+		}
+	}
+
+	return registries, nil
+}
+
+// registerBackendMetrics registers the Prometheus collectors of every
+// configured registry backend.
+func registerBackendMetrics(reg prometheus.Registerer) {
+	for _, backend := range registryBackends {
+		if backend == "coredns" {
+			coredns.RegisterMetrics(reg)
+		}
+	}
+}
+
+// dedupeStrings returns ss with duplicate values removed, preserving the
+// order of each value's first occurrence.
+func dedupeStrings(ss []string) []string {
+	seen := make(map[string]struct{}, len(ss))
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		out = append(out, s)
+	}
+	return out
+}
+
+// mergeStopChannels returns a channel that closes as soon as stopCh closes
+// or ctx is done, whichever happens first, so callers that only accept a
+// <-chan struct{} (informer factories, Controller.Run) can still observe
+// context cancellation.
+func mergeStopChannels(stopCh <-chan struct{}, ctx context.Context) <-chan struct{} {
+	merged := make(chan struct{})
+	go func() {
+		defer close(merged)
+		select {
+		case <-stopCh:
+		case <-ctx.Done():
+		}
+	}()
+	return merged
+}
+
+// loggingEventRecorder is a minimal resourcelock.EventRecorder that routes
+// leader election events through the controller's own logger instead of
+// requiring a full client-go event broadcaster.
+type loggingEventRecorder struct{}
+
+func (loggingEventRecorder) Eventf(_ runtime.Object, eventType, reason, message string, args ...interface{}) {
+	log.Infof("leader election event [%s] %s: %s", eventType, reason, fmt.Sprintf(message, args...))
+}
+
+// startMonitoringHTTPServer serves Prometheus metrics, and optionally
+// net/http/pprof profiles, on a dedicated port. It is kept separate from the
+// health check server so that scraping metrics can never be affected by
+// liveness/readiness probe load, and vice versa.
+func startMonitoringHTTPServer(port int, enableProfiling bool) {
 	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if enableProfiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	log.Infof("Monitoring HTTP server listening at :%d ... ", port)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: mux,
+	}
+	server.ListenAndServe()
+}
+
+// livenessMaxHeartbeatAge is how stale the reconcile loop's heartbeat may get
+// before /livez considers the process wedged.
+const livenessMaxHeartbeatAge = 2 * time.Minute
+
+// checkResult is one named check in a /readyz?verbose=1 breakdown.
+type checkResult struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	Err  string `json:"error,omitempty"`
+}
+
+func startHealthCheckHTTPServer(port int, stopCh <-chan struct{}) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		if c := getActiveController(); c != nil {
+			if hb := c.Heartbeat(); !hb.IsZero() && time.Since(hb) > livenessMaxHeartbeatAge {
+				http.Error(w, fmt.Sprintf("reconcile loop heartbeat is stale (last seen %s ago)", time.Since(hb)), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "ok, leader=%s\n", currentLeader)
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		coreDNSConfig := getCoreDNSConfig()
+		c := getActiveController()
+		results := []checkResult{
+			namedCheck("informers-synced", func() error {
+				if c == nil || !c.HasSynced() {
+					return fmt.Errorf("not yet synced (elected leader: %v)", c != nil)
+				}
+				return nil
+			}),
+		}
+		for _, backend := range registryBackends {
+			if backend != "coredns" {
+				continue
+			}
+			results = append(results,
+				namedCheck("etcd", func() error { return coredns.CheckEtcd(ctx, coreDNSConfig) }),
+				namedCheck("coredns", func() error { return coredns.CheckCoreDNS(ctx, coreDNSConfig.Address) }),
+			)
+		}
+
+		ready := true
+		for _, res := range results {
+			if !res.OK {
+				ready = false
+				break
+			}
+		}
+
+		if r.URL.Query().Get("verbose") == "1" {
+			w.Header().Set("Content-Type", "application/json")
+			if !ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			_ = json.NewEncoder(w).Encode(results)
+			return
+		}
+
+		if !ready {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "ok")
 	})
@@ -145,7 +502,26 @@ func startHealthCheckHTTPServer(port int) {
 		Addr:    fmt.Sprintf(":%v", port),
 		Handler: mux,
 	}
-	server.ListenAndServe()
+
+	go func() {
+		<-stopCh
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error shutting down health check HTTP server: %s", err.Error())
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("Health check HTTP server failed: %s", err.Error())
+	}
+}
+
+func namedCheck(name string, check func() error) checkResult {
+	if err := check(); err != nil {
+		return checkResult{Name: name, OK: false, Err: err.Error()}
+	}
+	return checkResult{Name: name, OK: true}
 }
 
 func main() {
@@ -164,6 +540,33 @@ func init() {
 	proxyCmd.PersistentFlags().StringVar(&etcdCertFile, "etcdcertfile", "", "Path to etcdcertfile.")
 	proxyCmd.PersistentFlags().StringVar(&etcdCaCertile, "etcdcacertfile", "", "Path to etcdcacertfile.")
 	proxyCmd.PersistentFlags().StringVar(&etcdEndpoints, "etcdendpoints", "", "Path to etcdendpoints.")
+	proxyCmd.PersistentFlags().BoolVar(&leaderElect, "leader-elect", false,
+		"Run the controller with leader election so only one of multiple replicas is active at a time.")
+	proxyCmd.PersistentFlags().DurationVar(&leaderElectLeaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"The duration that non-leader candidates will wait before forcing acquisition of leadership.")
+	proxyCmd.PersistentFlags().DurationVar(&leaderElectRenewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"The duration that the acting leader will retry refreshing leadership before giving it up.")
+	proxyCmd.PersistentFlags().DurationVar(&leaderElectRetryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"The duration clients should wait between attempting acquisition and renewal of leadership.")
+	proxyCmd.PersistentFlags().StringVar(&leaderElectResourceNamespace, "leader-elect-resource-namespace", "istio-system",
+		"The namespace of the Lease object used for leader election.")
+	proxyCmd.PersistentFlags().IntVar(&monitoringPort, "monitoring-port", 15014,
+		"The port serving Prometheus metrics and, if enabled, pprof profiles.")
+	proxyCmd.PersistentFlags().BoolVar(&enableProfiling, "enable-profiling", false,
+		"Expose net/http/pprof profiling endpoints on the monitoring port.")
+	proxyCmd.PersistentFlags().StringArrayVar(&registryBackends, "registry", []string{"coredns"},
+		"Service registry backend to publish RpcServices into (coredns|nacos|zookeeper|consul). May be repeated to fan out to several.")
+	proxyCmd.PersistentFlags().StringSliceVar(&nacosEndpoints, "nacos-endpoints", nil, "Comma-separated host:port addresses of the Nacos servers.")
+	proxyCmd.PersistentFlags().StringVar(&nacosNamespace, "nacos-namespace", "", "Nacos namespace ID to register RpcServices under.")
+	proxyCmd.PersistentFlags().StringVar(&nacosGroup, "nacos-group", "", "Nacos group to register RpcServices under.")
+	proxyCmd.PersistentFlags().StringSliceVar(&zkHosts, "zk-hosts", nil, "Comma-separated host:port addresses of the ZooKeeper ensemble.")
+	proxyCmd.PersistentFlags().StringVar(&zkBasePath, "zk-base-path", "/dubbo", "ZooKeeper znode prefix RpcServices are registered under.")
+	proxyCmd.PersistentFlags().StringVar(&consulAddr, "consul-addr", "127.0.0.1:8500", "Address of the local Consul agent's HTTP API.")
+	proxyCmd.PersistentFlags().StringVar(&consulToken, "consul-token", "", "ACL token used for Consul API requests.")
+	proxyCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"Path to a YAML or JSON config file. CLI flags override values loaded from it at startup, and "+
+			"CoreDNS/etcd settings and the log level are hot-reloaded from the file whenever it changes, "+
+			"even where the matching flag was set on the command line.")
 
 	// Attach the Istio logging options to the command.
 	loggingOptions.AttachCobraFlags(rootCmd)
@@ -0,0 +1,68 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/istio/pkg/rpccontroller/registry/coredns"
+)
+
+func TestBuildRegistriesUnknownBackend(t *testing.T) {
+	orig := registryBackends
+	t.Cleanup(func() { registryBackends = orig })
+
+	registryBackends = []string{"bogus"}
+
+	if _, err := buildRegistries(coredns.Config{}); err == nil {
+		t.Fatal("expected an error for an unknown --registry backend, got nil")
+	} else if !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("error = %v, want it to name the unknown backend", err)
+	}
+}
+
+func TestBuildRegistriesInvalidNacosEndpoint(t *testing.T) {
+	origBackends, origEndpoints := registryBackends, nacosEndpoints
+	t.Cleanup(func() {
+		registryBackends, nacosEndpoints = origBackends, origEndpoints
+	})
+
+	registryBackends = []string{"nacos"}
+	nacosEndpoints = []string{"not-a-host-port"}
+
+	if _, err := buildRegistries(coredns.Config{}); err == nil {
+		t.Fatal("expected an error for a malformed nacos endpoint, got nil")
+	} else if !strings.Contains(err.Error(), "nacos") {
+		t.Errorf("error = %v, want it wrapped with the nacos backend name", err)
+	}
+}
+
+func TestDedupeStrings(t *testing.T) {
+	got := dedupeStrings([]string{"coredns", "nacos", "coredns", "consul", "nacos"})
+	want := []string{"coredns", "nacos", "consul"}
+
+	if len(got) != len(want) {
+		t.Fatalf("dedupeStrings() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("dedupeStrings() = %v, want %v", got, want)
+		}
+	}
+}
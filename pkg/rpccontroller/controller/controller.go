@@ -0,0 +1,250 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package controller watches RpcService custom resources and publishes the
+// endpoints they describe into one or more configured service registries
+// (CoreDNS, Nacos, ZooKeeper, Consul, ...), so that rpc clients in the mesh
+// can discover them without being tied to any single registry.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+	clientset "istio.io/istio/pkg/rpccontroller/clientset/versioned"
+	informers "istio.io/istio/pkg/rpccontroller/informers/externalversions/rpccontroller/v1"
+	listers "istio.io/istio/pkg/rpccontroller/listers/rpccontroller/v1"
+	"istio.io/istio/pkg/rpccontroller/registry"
+
+	"istio.io/istio/pkg/log"
+)
+
+// Controller reconciles RpcService objects into the configured registries.
+type Controller struct {
+	kubeclientset    kubernetes.Interface
+	watcherclientset clientset.Interface
+
+	rpcServicesLister listers.RpcServiceLister
+	rpcServicesSynced cache.InformerSynced
+
+	workqueue workqueue.RateLimitingInterface
+
+	registries []registry.ServiceRegistry
+	stopCh     <-chan struct{}
+
+	// lastReconcile is a Unix nanosecond timestamp updated every time the
+	// reconcile loop comes back around, used to back a liveness probe. It is
+	// accessed with sync/atomic since it's read from the health HTTP server's
+	// goroutine.
+	lastReconcile int64
+}
+
+// NewController returns a new rpc-controller, wired to the given clientsets,
+// RpcService informer, and set of registries to fan RpcServices out to. The
+// returned controller does not start doing anything until Run is called.
+func NewController(
+	kubeclientset kubernetes.Interface,
+	watcherclientset clientset.Interface,
+	rpcServiceInformer informers.RpcServiceInformer,
+	registries []registry.ServiceRegistry,
+	stopCh <-chan struct{}) *Controller {
+
+	c := &Controller{
+		kubeclientset:     kubeclientset,
+		watcherclientset:  watcherclientset,
+		rpcServicesLister: rpcServiceInformer.Lister(),
+		rpcServicesSynced: rpcServiceInformer.Informer().HasSynced,
+		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "RpcServices"),
+		registries:        registries,
+		stopCh:            stopCh,
+	}
+
+	log.Info("Setting up event handlers")
+	rpcServiceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			rpcServiceEventsTotal.WithLabelValues(string(eventAdd), "enqueued").Inc()
+			c.enqueue(obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			rpcServiceEventsTotal.WithLabelValues(string(eventUpdate), "enqueued").Inc()
+			c.enqueue(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			rpcServiceEventsTotal.WithLabelValues(string(eventDelete), "enqueued").Inc()
+			c.enqueue(obj)
+		},
+	})
+
+	return c
+}
+
+// heartbeatInterval is how often Run stamps the heartbeat on its own,
+// independent of workqueue activity, so a liveness probe doesn't mistake an
+// idle controller (no RpcServices enqueued, so runWorker is blocked in
+// workqueue.Get) for a wedged one.
+const heartbeatInterval = 30 * time.Second
+
+// Run starts threadiness workers and blocks until stopCh is closed.
+func (c *Controller) Run(threadiness int) error {
+	defer runtime.HandleCrash()
+	defer c.workqueue.ShutDown()
+
+	log.Info("Starting RpcService controller")
+
+	log.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(c.stopCh, c.rpcServicesSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	go wait.Until(c.beat, heartbeatInterval, c.stopCh)
+
+	log.Info("Starting workers")
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(c.runWorker, time.Second, c.stopCh)
+	}
+
+	log.Info("Started workers")
+	<-c.stopCh
+	log.Info("Shutting down workers")
+
+	return nil
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.workqueue.Add(key)
+	workqueueDepth.Set(float64(c.workqueue.Len()))
+}
+
+func (c *Controller) runWorker() {
+	for {
+		c.beat()
+		if !c.processNextWorkItem() {
+			return
+		}
+	}
+}
+
+// beat stamps lastReconcile with the current time.
+func (c *Controller) beat() {
+	atomic.StoreInt64(&c.lastReconcile, time.Now().UnixNano())
+}
+
+// Heartbeat reports when the reconcile loop last came back around, so a
+// liveness probe can detect a wedged worker goroutine.
+func (c *Controller) Heartbeat() time.Time {
+	nanos := atomic.LoadInt64(&c.lastReconcile)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// HasSynced reports whether the RpcService informer cache has completed its
+// initial list.
+func (c *Controller) HasSynced() bool {
+	return c.rpcServicesSynced()
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer func() {
+		c.workqueue.Done(obj)
+		workqueueDepth.Set(float64(c.workqueue.Len()))
+	}()
+
+	key, ok := obj.(string)
+	if !ok {
+		c.workqueue.Forget(obj)
+		runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+
+	start := time.Now()
+	if err := c.syncHandler(key); err != nil {
+		reconcileLatencySeconds.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		workqueueRetriesTotal.Inc()
+		c.workqueue.AddRateLimited(key)
+		runtime.HandleError(fmt.Errorf("error syncing %q: %v, requeuing", key, err))
+		return true
+	}
+	reconcileLatencySeconds.WithLabelValues("success").Observe(time.Since(start).Seconds())
+
+	c.workqueue.Forget(obj)
+	return true
+}
+
+// syncHandler reconciles a single RpcService, identified by its
+// namespace/name key, into every configured registry.
+func (c *Controller) syncHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(err)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	rpcService, err := c.rpcServicesLister.RpcServices(namespace).Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			tombstone := &rpcsvcv1.RpcService{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			}
+			return c.deregisterAll(ctx, tombstone)
+		}
+		return err
+	}
+
+	return c.syncAll(ctx, rpcService)
+}
+
+func (c *Controller) syncAll(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	for _, reg := range c.registries {
+		if err := reg.Sync(ctx, svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) deregisterAll(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	for _, reg := range c.registries {
+		if err := reg.Deregister(ctx, svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
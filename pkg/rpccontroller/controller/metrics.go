@@ -0,0 +1,71 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventType identifies what kind of informer event a reconcile was triggered by.
+type eventType string
+
+const (
+	eventAdd    eventType = "add"
+	eventUpdate eventType = "update"
+	eventDelete eventType = "delete"
+)
+
+var (
+	rpcServiceEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rpc_controller",
+		Name:      "rpc_service_events_total",
+		Help:      "Number of RpcService add/update/delete events processed, by event type and outcome.",
+	}, []string{"event_type", "result"})
+
+	reconcileLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rpc_controller",
+		Name:      "reconcile_latency_seconds",
+		Help:      "Latency of reconciling a single RpcService into the configured registries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	workqueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "rpc_controller",
+		Name:      "workqueue_depth",
+		Help:      "Current depth of the RpcService workqueue.",
+	})
+
+	workqueueRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "rpc_controller",
+		Name:      "workqueue_retries_total",
+		Help:      "Number of times a workqueue item has been requeued after a failed sync.",
+	})
+)
+
+// RegisterMetrics registers this package's collectors with the given
+// Prometheus registerer. It is called once by cmd/rpc-controller before the
+// monitoring HTTP server starts serving /metrics; each configured
+// registry.ServiceRegistry backend registers its own collectors separately.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(
+		rpcServiceEventsTotal,
+		reconcileLatencySeconds,
+		workqueueDepth,
+		workqueueRetriesTotal,
+	)
+}
@@ -0,0 +1,143 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package consul publishes RpcService endpoints as Consul catalog services,
+// via the local Consul agent's HTTP API.
+package consul
+
+import (
+	"context"
+	"fmt"
+
+	consulapi "github.com/hashicorp/consul/api"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+// Config holds the settings needed to reach a Consul agent.
+type Config struct {
+	// Addr is the "host:port" address of the Consul agent's HTTP API.
+	Addr string
+	// Token is an optional ACL token used for every request.
+	Token string
+}
+
+// Registry publishes RpcService endpoints as Consul services.
+type Registry struct {
+	client *consulapi.Client
+}
+
+// New builds a Consul API client and returns a ready-to-use Registry.
+func New(config Config) (*Registry, error) {
+	cfg := consulapi.DefaultConfig()
+	cfg.Address = config.Addr
+	cfg.Token = config.Token
+
+	client, err := consulapi.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul client: %v", err)
+	}
+
+	return &Registry{client: client}, nil
+}
+
+func serviceName(svc *rpcsvcv1.RpcService) string {
+	return fmt.Sprintf("%s-%s", svc.Namespace, svc.Name)
+}
+
+func serviceID(svc *rpcsvcv1.RpcService, ip string, port int32) string {
+	return fmt.Sprintf("%s-%s-%d", serviceName(svc), ip, port)
+}
+
+// Register implements registry.ServiceRegistry.
+func (r *Registry) Register(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+	for _, ep := range svc.Spec.Endpoints {
+		reg := &consulapi.AgentServiceRegistration{
+			ID:      serviceID(svc, ep.IP, ep.Port),
+			Name:    name,
+			Address: ep.IP,
+			Port:    int(ep.Port),
+			Tags:    []string{"rpc-controller", svc.Namespace},
+		}
+		if err := r.client.Agent().ServiceRegister(reg); err != nil {
+			return fmt.Errorf("failed to register %s instance %s:%d in consul: %v", name, ep.IP, ep.Port, err)
+		}
+	}
+
+	log.Debugf("registered %d endpoints for %s in consul", len(svc.Spec.Endpoints), name)
+	return nil
+}
+
+// Deregister implements registry.ServiceRegistry.
+func (r *Registry) Deregister(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+
+	services, err := r.client.Agent().ServicesWithFilter(fmt.Sprintf("Service == %q", name))
+	if err != nil {
+		return fmt.Errorf("failed to list consul services for %s: %v", name, err)
+	}
+
+	for id := range services {
+		if err := r.client.Agent().ServiceDeregister(id); err != nil {
+			return fmt.Errorf("failed to deregister consul service %s: %v", id, err)
+		}
+	}
+
+	log.Debugf("deregistered %s from consul", name)
+	return nil
+}
+
+// Sync implements registry.ServiceRegistry by registering svc's current
+// endpoints and deregistering only the instances no longer present in its
+// spec, so a routine resync doesn't bounce every endpoint through a
+// deregister/register cycle.
+func (r *Registry) Sync(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	if err := r.deregisterStale(svc); err != nil {
+		return err
+	}
+	return r.Register(ctx, svc)
+}
+
+// deregisterStale removes the Consul services currently registered for svc
+// that are no longer present in svc.Spec.Endpoints.
+func (r *Registry) deregisterStale(svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+
+	services, err := r.client.Agent().ServicesWithFilter(fmt.Sprintf("Service == %q", name))
+	if err != nil {
+		return fmt.Errorf("failed to list consul services for %s: %v", name, err)
+	}
+
+	desired := make(map[string]struct{}, len(svc.Spec.Endpoints))
+	for _, ep := range svc.Spec.Endpoints {
+		desired[serviceID(svc, ep.IP, ep.Port)] = struct{}{}
+	}
+
+	for id := range services {
+		if _, ok := desired[id]; ok {
+			continue
+		}
+		if err := r.client.Agent().ServiceDeregister(id); err != nil {
+			return fmt.Errorf("failed to deregister stale consul service %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
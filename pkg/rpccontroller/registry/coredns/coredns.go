@@ -0,0 +1,275 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package coredns publishes RpcService endpoints into CoreDNS's etcd-backed
+// zone, in the SkyDNS message format the etcd plugin expects.
+package coredns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	clientv3 "go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/pkg/transport"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const etcdDialTimeout = 5 * time.Second
+
+// Config holds the settings needed to reach CoreDNS and the etcd store
+// backing it.
+type Config struct {
+	// Address is the gRPC address of the CoreDNS instance serving the mesh
+	// zone.
+	Address string
+
+	EtcdKeyFile    string
+	EtcdCertFile   string
+	EtcdCaCertFile string
+	EtcdEndpoints  []string
+}
+
+// Registry publishes RpcService endpoints into CoreDNS's etcd zone.
+//
+// Its etcd client and CoreDNS connection are guarded by mu so that Reload
+// can swap them out from under a running Register/Deregister/Sync call,
+// letting rpc-controller pick up new etcd endpoints or rotated TLS material
+// without a restart.
+type Registry struct {
+	mu          sync.RWMutex
+	config      Config
+	etcdClient  *clientv3.Client
+	corednsConn *grpc.ClientConn
+}
+
+// New dials etcd and CoreDNS and returns a ready-to-use Registry.
+func New(config Config) (*Registry, error) {
+	etcdClient, err := newEtcdClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %v", err)
+	}
+
+	conn, err := grpc.Dial(config.Address, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial coredns at %s: %v", config.Address, err)
+	}
+
+	return &Registry{config: config, etcdClient: etcdClient, corednsConn: conn}, nil
+}
+
+// Reload dials a new etcd client and CoreDNS connection for config and
+// atomically swaps them in, closing the previous ones. It lets the etcd
+// endpoints and TLS material be rotated at runtime, e.g. in response to a
+// config file hot-reload. The previous etcd client is closed as soon as the
+// swap is made, so a Register/Deregister call still running on it when it
+// is closed returns that client's error.
+func (r *Registry) Reload(config Config) error {
+	etcdClient, err := newEtcdClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create etcd client: %v", err)
+	}
+
+	conn, err := grpc.Dial(config.Address, grpc.WithInsecure())
+	if err != nil {
+		etcdClient.Close()
+		return fmt.Errorf("failed to dial coredns at %s: %v", config.Address, err)
+	}
+
+	r.mu.Lock()
+	oldEtcdClient, oldConn := r.etcdClient, r.corednsConn
+	r.config, r.etcdClient, r.corednsConn = config, etcdClient, conn
+	r.mu.Unlock()
+
+	oldEtcdClient.Close()
+	oldConn.Close()
+
+	log.Infof("reloaded coredns registry config: address=%s etcdEndpoints=%v", config.Address, config.EtcdEndpoints)
+	return nil
+}
+
+func (r *Registry) clients() (*clientv3.Client, *grpc.ClientConn) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.etcdClient, r.corednsConn
+}
+
+func newEtcdClient(config Config) (*clientv3.Client, error) {
+	tlsInfo := transport.TLSInfo{
+		CertFile:      config.EtcdCertFile,
+		KeyFile:       config.EtcdKeyFile,
+		TrustedCAFile: config.EtcdCaCertFile,
+	}
+
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build etcd TLS config: %v", err)
+	}
+
+	return clientv3.New(clientv3.Config{
+		Endpoints:   config.EtcdEndpoints,
+		DialTimeout: etcdDialTimeout,
+		TLS:         tlsConfig,
+	})
+}
+
+// skyDNSRecord is the subset of the SkyDNS/CoreDNS etcd plugin message
+// format that rpc-controller needs to populate.
+type skyDNSRecord struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+func etcdKey(namespace, name string) string {
+	return fmt.Sprintf("/skydns/mesh/svc/%s/%s", namespace, name)
+}
+
+// Register implements registry.ServiceRegistry.
+func (r *Registry) Register(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	key := etcdKey(svc.Namespace, svc.Name)
+	etcdClient, _ := r.clients()
+
+	for _, ep := range svc.Spec.Endpoints {
+		record, err := json.Marshal(skyDNSRecord{Host: ep.IP, Port: int(ep.Port)})
+		if err != nil {
+			return fmt.Errorf("failed to marshal skydns record for %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+
+		endpointKey := fmt.Sprintf("%s/%s", key, ep.IP)
+		start := time.Now()
+		_, err = etcdClient.Put(ctx, endpointKey, string(record))
+		etcdWriteLatencySeconds.WithLabelValues("put").Observe(time.Since(start).Seconds())
+		if err != nil {
+			etcdWriteErrorsTotal.WithLabelValues("put").Inc()
+			return fmt.Errorf("failed to write etcd key %s: %v", endpointKey, err)
+		}
+	}
+
+	log.Debugf("registered %d endpoints for %s/%s in coredns", len(svc.Spec.Endpoints), svc.Namespace, svc.Name)
+	return nil
+}
+
+// Deregister implements registry.ServiceRegistry.
+func (r *Registry) Deregister(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	key := etcdKey(svc.Namespace, svc.Name)
+	etcdClient, _ := r.clients()
+
+	start := time.Now()
+	_, err := etcdClient.Delete(ctx, key+"/", clientv3.WithPrefix())
+	etcdWriteLatencySeconds.WithLabelValues("delete").Observe(time.Since(start).Seconds())
+	if err != nil {
+		etcdWriteErrorsTotal.WithLabelValues("delete").Inc()
+		return fmt.Errorf("failed to delete etcd key %s: %v", key, err)
+	}
+
+	log.Debugf("deregistered %s/%s from coredns", svc.Namespace, svc.Name)
+	return nil
+}
+
+// Sync implements registry.ServiceRegistry. CoreDNS's etcd plugin has no
+// notion of "upsert with diff", so syncing is the same as registering: every
+// current endpoint is written. Keys of endpoints no longer in svc's spec are
+// not removed; they stay in etcd until Deregister deletes all of svc's keys.
+func (r *Registry) Sync(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	return r.Register(ctx, svc)
+}
+
+// CheckEtcd dials the configured etcd endpoints and issues a single Status
+// call, independent of any running Registry. It exists so a readiness probe
+// can verify etcd connectivity even on a replica that hasn't been elected
+// leader and so has no Registry constructed yet.
+func CheckEtcd(ctx context.Context, config Config) error {
+	if len(config.EtcdEndpoints) == 0 {
+		return fmt.Errorf("no etcd endpoints configured")
+	}
+
+	client, err := newEtcdClient(config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Status(ctx, config.EtcdEndpoints[0])
+	return err
+}
+
+// CheckCoreDNS dials addr and issues a single gRPC health check, independent
+// of any running Registry, recording the call's latency and error metrics.
+// This is rpc-controller's only gRPC call against CoreDNS (Register/
+// Deregister/Sync write straight to etcd), so it's the sole place those
+// metrics ever get a data point; it is called from /readyz on every probe.
+func CheckCoreDNS(ctx context.Context, addr string) error {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("failed to dial coredns at %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	start := time.Now()
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	corednsCallLatencySeconds.WithLabelValues("Check").Observe(time.Since(start).Seconds())
+	if err != nil {
+		corednsCallErrorsTotal.WithLabelValues("Check").Inc()
+	}
+	return err
+}
+
+// KeyDump is a single etcd key captured for a diagnostics bundle, with both
+// the raw stored value and, where it parses as one, the decoded SkyDNS
+// record.
+type KeyDump struct {
+	Key     string        `json:"key"`
+	Raw     string        `json:"raw"`
+	Decoded *skyDNSRecord `json:"decoded,omitempty"`
+}
+
+// DumpKeys returns every etcd key stored under namespace/name's prefix,
+// independent of any running Registry. It is used by `rpc-controller dump`
+// to bundle a service's current CoreDNS state into a diagnostics artifact.
+func DumpKeys(ctx context.Context, config Config, namespace, name string) ([]KeyDump, error) {
+	client, err := newEtcdClient(config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	resp, err := client.Get(ctx, etcdKey(namespace, name)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read etcd keys for %s/%s: %v", namespace, name, err)
+	}
+
+	dumps := make([]KeyDump, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		dump := KeyDump{Key: string(kv.Key), Raw: string(kv.Value)}
+		var record skyDNSRecord
+		if err := json.Unmarshal(kv.Value, &record); err == nil {
+			dump.Decoded = &record
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps, nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coredns
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	corednsCallLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rpc_controller",
+		Subsystem: "coredns",
+		Name:      "call_latency_seconds",
+		Help:      "Latency of gRPC calls made to CoreDNS.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	corednsCallErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rpc_controller",
+		Subsystem: "coredns",
+		Name:      "call_errors_total",
+		Help:      "Number of failed gRPC calls made to CoreDNS.",
+	}, []string{"method"})
+
+	etcdWriteLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rpc_controller",
+		Subsystem: "coredns",
+		Name:      "etcd_write_latency_seconds",
+		Help:      "Latency of etcd writes (put/delete) made while syncing RpcServices.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	etcdWriteErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rpc_controller",
+		Subsystem: "coredns",
+		Name:      "etcd_write_errors_total",
+		Help:      "Number of failed etcd writes made while syncing RpcServices.",
+	}, []string{"op"})
+)
+
+// RegisterMetrics registers this package's collectors with reg. Like other
+// prometheus.MustRegister callers, it panics if called more than once with
+// the same registry; callers that may build more than one coredns.Registry
+// (e.g. from a repeatable --registry flag) must dedupe backends first.
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(
+		corednsCallLatencySeconds,
+		corednsCallErrorsTotal,
+		etcdWriteLatencySeconds,
+		etcdWriteErrorsTotal,
+	)
+}
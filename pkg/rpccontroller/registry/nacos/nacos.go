@@ -0,0 +1,186 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package nacos publishes RpcService endpoints into a Nacos naming service,
+// for sofa-mesh deployments migrating from a SOFA/Dubbo stack that already
+// registers services in Nacos.
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+// Config holds the settings needed to reach a Nacos naming server.
+type Config struct {
+	// Endpoints is a list of "host:port" Nacos server addresses.
+	Endpoints []string
+	// NamespaceID scopes registrations to a Nacos namespace. Empty means the
+	// default "public" namespace.
+	NamespaceID string
+	// Group scopes registrations to a Nacos group. Empty means Nacos's
+	// default group.
+	Group string
+}
+
+// Registry publishes RpcService endpoints as Nacos service instances.
+type Registry struct {
+	client naming_client.INamingClient
+	group  string
+}
+
+// New builds a Nacos naming client and returns a ready-to-use Registry.
+func New(config Config) (*Registry, error) {
+	serverConfigs := make([]constant.ServerConfig, 0, len(config.Endpoints))
+	for _, ep := range config.Endpoints {
+		host, portStr, err := splitHostPort(ep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nacos endpoint %q: %v", ep, err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nacos endpoint port %q: %v", ep, err)
+		}
+		serverConfigs = append(serverConfigs, constant.ServerConfig{IpAddr: host, Port: port})
+	}
+
+	client, err := clients.CreateNamingClient(map[string]interface{}{
+		"serverConfigs": serverConfigs,
+		"clientConfig": constant.ClientConfig{
+			NamespaceId: config.NamespaceID,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nacos naming client: %v", err)
+	}
+
+	return &Registry{client: client, group: config.Group}, nil
+}
+
+func splitHostPort(endpoint string) (host, port string, err error) {
+	parts := strings.SplitN(endpoint, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expected host:port")
+	}
+	return parts[0], parts[1], nil
+}
+
+func serviceName(svc *rpcsvcv1.RpcService) string {
+	return fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+}
+
+// Register implements registry.ServiceRegistry.
+func (r *Registry) Register(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+	for _, ep := range svc.Spec.Endpoints {
+		ok, err := r.client.RegisterInstance(vo.RegisterInstanceParam{
+			Ip:          ep.IP,
+			Port:        uint64(ep.Port),
+			ServiceName: name,
+			GroupName:   r.group,
+			Weight:      1,
+			Enable:      true,
+			Healthy:     true,
+			Ephemeral:   true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register %s instance %s:%d in nacos: %v", name, ep.IP, ep.Port, err)
+		}
+		if !ok {
+			return fmt.Errorf("nacos rejected registration of %s instance %s:%d", name, ep.IP, ep.Port)
+		}
+	}
+
+	log.Debugf("registered %d endpoints for %s in nacos", len(svc.Spec.Endpoints), name)
+	return nil
+}
+
+// Deregister implements registry.ServiceRegistry.
+func (r *Registry) Deregister(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+	instances, err := r.client.SelectAllInstances(vo.SelectAllInstancesParam{ServiceName: name, GroupName: r.group})
+	if err != nil {
+		return fmt.Errorf("failed to list nacos instances for %s: %v", name, err)
+	}
+
+	for _, inst := range instances {
+		if _, err := r.client.DeregisterInstance(vo.DeregisterInstanceParam{
+			Ip:          inst.Ip,
+			Port:        inst.Port,
+			ServiceName: name,
+			GroupName:   r.group,
+		}); err != nil {
+			return fmt.Errorf("failed to deregister %s instance %s:%d from nacos: %v", name, inst.Ip, inst.Port, err)
+		}
+	}
+
+	log.Debugf("deregistered %s from nacos", name)
+	return nil
+}
+
+// Sync implements registry.ServiceRegistry by registering svc's current
+// endpoints and deregistering only the instances no longer present in its
+// spec, so a routine resync doesn't bounce every endpoint through a
+// deregister/register cycle.
+func (r *Registry) Sync(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	if err := r.deregisterStale(svc); err != nil {
+		return err
+	}
+	return r.Register(ctx, svc)
+}
+
+// deregisterStale removes the instances currently registered for svc in
+// Nacos that are no longer present in svc.Spec.Endpoints.
+func (r *Registry) deregisterStale(svc *rpcsvcv1.RpcService) error {
+	name := serviceName(svc)
+	instances, err := r.client.SelectAllInstances(vo.SelectAllInstancesParam{ServiceName: name, GroupName: r.group})
+	if err != nil {
+		return fmt.Errorf("failed to list nacos instances for %s: %v", name, err)
+	}
+
+	desired := make(map[string]struct{}, len(svc.Spec.Endpoints))
+	for _, ep := range svc.Spec.Endpoints {
+		desired[fmt.Sprintf("%s:%d", ep.IP, ep.Port)] = struct{}{}
+	}
+
+	for _, inst := range instances {
+		if _, ok := desired[fmt.Sprintf("%s:%d", inst.Ip, inst.Port)]; ok {
+			continue
+		}
+		if _, err := r.client.DeregisterInstance(vo.DeregisterInstanceParam{
+			Ip:          inst.Ip,
+			Port:        inst.Port,
+			ServiceName: name,
+			GroupName:   r.group,
+		}); err != nil {
+			return fmt.Errorf("failed to deregister stale %s instance %s:%d from nacos: %v", name, inst.Ip, inst.Port, err)
+		}
+	}
+
+	return nil
+}
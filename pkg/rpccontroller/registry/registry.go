@@ -0,0 +1,44 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package registry defines the ServiceRegistry interface that backs
+// rpc-controller's publishing of RpcService endpoints into external service
+// registries (CoreDNS, Nacos, ZooKeeper, Consul, ...), and lets the
+// controller fan a single RpcService out to several of them at once.
+package registry
+
+import (
+	"context"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+)
+
+// ServiceRegistry is implemented by each backend rpc-controller can publish
+// RpcService endpoints into. Implementations must be safe for concurrent use,
+// since the controller may reconcile several RpcServices in parallel.
+type ServiceRegistry interface {
+	// Register publishes svc's endpoints into the registry.
+	Register(ctx context.Context, svc *rpcsvcv1.RpcService) error
+
+	// Deregister removes svc's endpoints from the registry. svc carries only
+	// the namespace/name of the deleted RpcService; its spec may be empty.
+	Deregister(ctx context.Context, svc *rpcsvcv1.RpcService) error
+
+	// Sync reconciles the registry's view of svc with its current spec,
+	// registering it if absent and updating it otherwise.
+	Sync(ctx context.Context, svc *rpcsvcv1.RpcService) error
+}
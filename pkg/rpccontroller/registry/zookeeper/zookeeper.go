@@ -0,0 +1,176 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package zookeeper publishes RpcService endpoints as ephemeral znodes, in
+// the layout Dubbo's ZooKeeper registry expects, for sofa-mesh deployments
+// migrating from a Dubbo stack backed by ZooKeeper.
+package zookeeper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+
+	rpcsvcv1 "istio.io/istio/pkg/rpccontroller/apis/rpccontroller/v1"
+
+	"istio.io/istio/pkg/log"
+)
+
+const sessionTimeout = 10 * time.Second
+
+// Config holds the settings needed to reach a ZooKeeper ensemble.
+type Config struct {
+	// Hosts is a list of "host:port" ZooKeeper server addresses.
+	Hosts []string
+	// BasePath is the znode prefix services are registered under, e.g.
+	// "/dubbo".
+	BasePath string
+}
+
+// Registry publishes RpcService endpoints as ephemeral znodes.
+type Registry struct {
+	conn     *zk.Conn
+	basePath string
+}
+
+// New connects to the ZooKeeper ensemble and returns a ready-to-use Registry.
+func New(config Config) (*Registry, error) {
+	conn, _, err := zk.Connect(config.Hosts, sessionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to zookeeper: %v", err)
+	}
+
+	basePath := config.BasePath
+	if basePath == "" {
+		basePath = "/dubbo"
+	}
+
+	return &Registry{conn: conn, basePath: basePath}, nil
+}
+
+func (r *Registry) servicePath(svc *rpcsvcv1.RpcService) string {
+	return fmt.Sprintf("%s/%s.%s/providers", r.basePath, svc.Name, svc.Namespace)
+}
+
+func (r *Registry) ensurePath(path string) error {
+	if path == "" || path == "/" {
+		return nil
+	}
+
+	exists, _, err := r.conn.Exists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	parent := path[:strings.LastIndex(path, "/")]
+	if err := r.ensurePath(parent); err != nil {
+		return err
+	}
+
+	_, err = r.conn.Create(path, []byte{}, 0, zk.WorldACL(zk.PermAll))
+	if err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+	return nil
+}
+
+// Register implements registry.ServiceRegistry.
+func (r *Registry) Register(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	path := r.servicePath(svc)
+	if err := r.ensurePath(path); err != nil {
+		return fmt.Errorf("failed to create zookeeper path %s: %v", path, err)
+	}
+
+	for _, ep := range svc.Spec.Endpoints {
+		nodePath := fmt.Sprintf("%s/%s:%d", path, ep.IP, ep.Port)
+		if _, err := r.conn.Create(nodePath, []byte{}, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+			return fmt.Errorf("failed to create zookeeper node %s: %v", nodePath, err)
+		}
+	}
+
+	log.Debugf("registered %d endpoints for %s/%s in zookeeper", len(svc.Spec.Endpoints), svc.Namespace, svc.Name)
+	return nil
+}
+
+// Deregister implements registry.ServiceRegistry.
+func (r *Registry) Deregister(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	path := r.servicePath(svc)
+
+	children, _, err := r.conn.Children(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return fmt.Errorf("failed to list zookeeper children of %s: %v", path, err)
+	}
+
+	for _, child := range children {
+		if err := r.conn.Delete(fmt.Sprintf("%s/%s", path, child), -1); err != nil && err != zk.ErrNoNode {
+			return fmt.Errorf("failed to delete zookeeper node %s/%s: %v", path, child, err)
+		}
+	}
+
+	log.Debugf("deregistered %s/%s from zookeeper", svc.Namespace, svc.Name)
+	return nil
+}
+
+// Sync implements registry.ServiceRegistry by registering svc's current
+// endpoints and deregistering only the provider nodes no longer present in
+// its spec, so a routine resync doesn't bounce every endpoint through a
+// delete/create cycle.
+func (r *Registry) Sync(ctx context.Context, svc *rpcsvcv1.RpcService) error {
+	if err := r.deregisterStale(svc); err != nil {
+		return err
+	}
+	return r.Register(ctx, svc)
+}
+
+// deregisterStale removes the provider znodes currently registered for svc
+// that are no longer present in svc.Spec.Endpoints.
+func (r *Registry) deregisterStale(svc *rpcsvcv1.RpcService) error {
+	path := r.servicePath(svc)
+
+	children, _, err := r.conn.Children(path)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return fmt.Errorf("failed to list zookeeper children of %s: %v", path, err)
+	}
+
+	desired := make(map[string]struct{}, len(svc.Spec.Endpoints))
+	for _, ep := range svc.Spec.Endpoints {
+		desired[fmt.Sprintf("%s:%d", ep.IP, ep.Port)] = struct{}{}
+	}
+
+	for _, child := range children {
+		if _, ok := desired[child]; ok {
+			continue
+		}
+		if err := r.conn.Delete(fmt.Sprintf("%s/%s", path, child), -1); err != nil && err != zk.ErrNoNode {
+			return fmt.Errorf("failed to delete stale zookeeper node %s/%s: %v", path, child, err)
+		}
+	}
+
+	return nil
+}